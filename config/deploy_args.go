@@ -3,8 +3,15 @@ package config
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 )
 
+// kmsKeyARNPattern matches ARNs for KMS keys and aliases, eg
+// arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab
+// or arn:aws:kms:eu-west-1:111122223333:alias/my-key
+var kmsKeyARNPattern = regexp.MustCompile(`^arn:aws:kms:[a-z0-9-]+:\d{12}:(key|alias)/.+$`)
+
 // DeployArgs are arguments passed to the deploy command
 type DeployArgs struct {
 	IAAS        string
@@ -20,8 +27,40 @@ type DeployArgs struct {
 	// DBSizeIsSet is true if the user has manually specified the db-size (ie, it's not the default)
 	DBSizeIsSet bool
 	RestrictIPs string
+
+	// S3Endpoint points the state bucket's S3 client at a non-AWS S3-compatible
+	// endpoint, eg a MinIO instance or DigitalOcean Spaces
+	S3Endpoint string
+	// S3ForcePathStyle forces path-style addressing, which most non-AWS
+	// S3-compatible providers require
+	S3ForcePathStyle bool
+	// S3Region overrides the region used for S3 calls when it differs from AWSRegion.
+	// It is applied only when S3Endpoint is set
+	S3Region string
+	// S3DisableSSL disables TLS for the S3 endpoint, for talking to a local
+	// MinIO instance over plain HTTP
+	S3DisableSSL bool
+
+	// KMSKeyID is the ARN of a KMS key used to encrypt the state bucket and
+	// the objects written to it. When empty, the bucket falls back to SSE-S3 (AES256)
+	KMSKeyID string
+
+	// LargeAssetThreshold is the size in bytes at or above which WriteFile/LoadFile
+	// delegate to WriteStream/LoadStream instead, so multi-hundred-MB
+	// stemcells and worker tarballs aren't read fully into memory
+	LargeAssetThreshold int64
+
+	// SharedBucket is the name of an existing, already-versioned bucket that
+	// this deployment should store its state in alongside other deployments,
+	// instead of provisioning a bucket of its own
+	SharedBucket string
+	// BucketPrefix namespaces this deployment's keys within SharedBucket
+	BucketPrefix string
 }
 
+// DefaultLargeAssetThreshold is used when LargeAssetThreshold is unset (zero value)
+const DefaultLargeAssetThreshold int64 = 32 * 1024 * 1024
+
 // WorkerSizes are the permitted concourse worker sizes
 var WorkerSizes = []string{"medium", "large", "xlarge", "2xlarge", "4xlarge", "10xlarge", "16xlarge"}
 
@@ -56,6 +95,18 @@ func (args DeployArgs) Validate() error {
 		return err
 	}
 
+	if err := args.validateS3Fields(); err != nil {
+		return err
+	}
+
+	if err := args.validateEncryptionFields(); err != nil {
+		return err
+	}
+
+	if err := args.validateSharedBucketFields(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -102,3 +153,42 @@ func (args DeployArgs) validateDBFields() error {
 
 	return nil
 }
+
+func (args DeployArgs) validateS3Fields() error {
+	if args.S3ForcePathStyle && args.S3Endpoint == "" {
+		return errors.New("--s3-force-path-style requires --s3-endpoint to also be provided")
+	}
+	if args.S3DisableSSL && args.S3Endpoint == "" {
+		return errors.New("--s3-disable-ssl requires --s3-endpoint to also be provided")
+	}
+
+	return nil
+}
+
+func (args DeployArgs) validateEncryptionFields() error {
+	if args.KMSKeyID != "" && !kmsKeyARNPattern.MatchString(args.KMSKeyID) {
+		return fmt.Errorf("--kms-key-id must be a full KMS key or alias ARN, got: `%s`", args.KMSKeyID)
+	}
+
+	return nil
+}
+
+func (args DeployArgs) validateSharedBucketFields() error {
+	if args.BucketPrefix != "" && args.SharedBucket == "" {
+		return errors.New("--bucket-prefix requires --shared-bucket to also be provided")
+	}
+
+	if args.SharedBucket != "" && args.BucketPrefix == "" {
+		return errors.New("--shared-bucket requires --bucket-prefix to also be provided, so this deployment's state can't collide with or delete another deployment's state in the same bucket")
+	}
+
+	if strings.Contains(args.BucketPrefix, "..") {
+		return fmt.Errorf("--bucket-prefix must not contain `..`, got: `%s`", args.BucketPrefix)
+	}
+
+	if strings.HasPrefix(args.BucketPrefix, "/") {
+		return fmt.Errorf("--bucket-prefix must not start with `/`, got: `%s`", args.BucketPrefix)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package iaas
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestListObjectVersionsAndMarkers_AggregatesPaginatedResults(t *testing.T) {
+	pages := []*s3.ListObjectVersionsOutput{
+		{
+			Versions: []*s3.ObjectVersion{
+				{Key: aws.String("a"), VersionId: aws.String("v1")},
+			},
+		},
+		{
+			Versions: []*s3.ObjectVersion{
+				{Key: aws.String("b"), VersionId: aws.String("v1")},
+			},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{
+				{Key: aws.String("c"), VersionId: aws.String("v1")},
+			},
+		},
+	}
+
+	fake := &fakeS3API{
+		listObjectVersionsPagesFn: func(in *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool) error {
+			for i, page := range pages {
+				if !fn(page, i == len(pages)-1) {
+					break
+				}
+			}
+			return nil
+		},
+	}
+
+	client := &AWSProvider{}
+	identifiers, err := client.listObjectVersionsAndMarkers(fake, "my-bucket")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(identifiers) != 3 {
+		t.Fatalf("expected 3 identifiers (2 versions + 1 delete marker), got %d", len(identifiers))
+	}
+}
+
+func TestListObjectVersionsAndMarkers_RefusesUnprefixedSharedBucket(t *testing.T) {
+	client := &AWSProvider{sharedBucket: "shared"}
+
+	_, err := client.listObjectVersionsAndMarkers(&fakeS3API{}, "shared")
+	if err == nil {
+		t.Fatal("expected an error listing every version of a shared bucket with no prefix configured")
+	}
+}
+
+func TestBatchDeleteObjects_SplitsIntoMaxSizedBatches(t *testing.T) {
+	identifiers := make([]*s3.ObjectIdentifier, maxDeleteBatchSize+1)
+	for i := range identifiers {
+		identifiers[i] = &s3.ObjectIdentifier{Key: aws.String("key")}
+	}
+
+	var batchSizes []int
+	fake := &fakeS3API{
+		deleteObjectsFn: func(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+			batchSizes = append(batchSizes, len(in.Delete.Objects))
+			return &s3.DeleteObjectsOutput{}, nil
+		},
+	}
+
+	client := &AWSProvider{deleteWorkerCount: 1}
+	if err := client.batchDeleteObjects(fake, "my-bucket", identifiers); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(batchSizes) != 2 {
+		t.Fatalf("expected 2 batches for %d objects, got %d", len(identifiers), len(batchSizes))
+	}
+}
+
+func TestBatchDeleteObjects_PropagatesPerObjectErrors(t *testing.T) {
+	identifiers := []*s3.ObjectIdentifier{{Key: aws.String("key")}}
+
+	fake := &fakeS3API{
+		deleteObjectsFn: func(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+			return &s3.DeleteObjectsOutput{
+				Errors: []*s3.Error{{Key: aws.String("key"), Message: aws.String("access denied")}},
+			}, nil
+		},
+	}
+
+	client := &AWSProvider{}
+	if err := client.batchDeleteObjects(fake, "my-bucket", identifiers); err == nil {
+		t.Fatal("expected per-object delete errors to be propagated, not swallowed")
+	}
+}
+
+func TestIsRetriableBucketDeleteError(t *testing.T) {
+	cases := []struct {
+		code      string
+		retriable bool
+	}{
+		{awsErrCodeNoSuchBucket, true},
+		{"OperationAborted", true},
+		{"AccessDenied", false},
+	}
+
+	for _, c := range cases {
+		err := fakeAWSErr{code: c.code}
+		if got := isRetriableBucketDeleteError(err); got != c.retriable {
+			t.Errorf("code %q: expected retriable=%v, got %v", c.code, c.retriable, got)
+		}
+	}
+}
+
+func TestRetryDeleteBucket_RetriesUntilSuccess(t *testing.T) {
+	attempts := 0
+	fake := &fakeS3API{
+		deleteBucketFn: func(*s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, fakeAWSErr{code: awsErrCodeNoSuchBucket}
+			}
+			return &s3.DeleteBucketOutput{}, nil
+		},
+	}
+
+	client := &AWSProvider{}
+	if err := client.retryDeleteBucket(fake, "my-bucket"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestRetryDeleteBucket_DoesNotRetryNonRetriableErrors(t *testing.T) {
+	attempts := 0
+	fake := &fakeS3API{
+		deleteBucketFn: func(*s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+			attempts++
+			return nil, fakeAWSErr{code: "AccessDenied"}
+		},
+	}
+
+	client := &AWSProvider{}
+	if err := client.retryDeleteBucket(fake, "my-bucket"); err == nil {
+		t.Fatal("expected a non-retriable error to be returned immediately")
+	}
+	if attempts != 1 {
+		t.Errorf("expected exactly 1 attempt for a non-retriable error, got %d", attempts)
+	}
+}
+
+var _ awserr.Error = fakeAWSErr{}
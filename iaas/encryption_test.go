@@ -0,0 +1,90 @@
+package iaas
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestServerSideEncryption_DefaultsToAES256(t *testing.T) {
+	client := &AWSProvider{}
+
+	algorithm, kmsKeyID := client.serverSideEncryption()
+
+	if algorithm != s3.ServerSideEncryptionAes256 {
+		t.Errorf("expected algorithm %q, got %q", s3.ServerSideEncryptionAes256, algorithm)
+	}
+	if kmsKeyID != nil {
+		t.Errorf("expected no KMS key ID, got %q", *kmsKeyID)
+	}
+}
+
+func TestServerSideEncryption_UsesKMSWhenKeyIDSet(t *testing.T) {
+	const keyID = "arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	client := &AWSProvider{kmsKeyID: keyID}
+
+	algorithm, kmsKeyID := client.serverSideEncryption()
+
+	if algorithm != s3.ServerSideEncryptionAwsKms {
+		t.Errorf("expected algorithm %q, got %q", s3.ServerSideEncryptionAwsKms, algorithm)
+	}
+	if kmsKeyID == nil || *kmsKeyID != keyID {
+		t.Errorf("expected KMS key ID %q, got %v", keyID, kmsKeyID)
+	}
+}
+
+// fakeAWSErr satisfies awserr.Error with an arbitrary error code, eg the one
+// a backend that doesn't implement bucket versioning returns
+type fakeAWSErr struct {
+	code string
+}
+
+func (e fakeAWSErr) Error() string   { return e.code }
+func (e fakeAWSErr) Code() string    { return e.code }
+func (e fakeAWSErr) Message() string { return e.code }
+func (e fakeAWSErr) OrigErr() error  { return nil }
+
+var _ awserr.Error = fakeAWSErr{}
+
+func TestCreateBucket_StillAppliesEncryptionWhenVersioningUnsupported(t *testing.T) {
+	var encryptionCalled bool
+
+	fake := &fakeS3API{
+		putBucketVersioningFn: func(*s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+			return nil, fakeAWSErr{code: "NotImplemented"}
+		},
+		putBucketEncryptionFn: func(in *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
+			encryptionCalled = true
+			return &s3.PutBucketEncryptionOutput{}, nil
+		},
+	}
+
+	client := &AWSProvider{s3ClientOverride: fake, s3Endpoint: "http://minio.local"}
+
+	if err := client.CreateBucket("my-bucket"); err != nil {
+		t.Fatalf("expected CreateBucket to succeed, got: %v", err)
+	}
+
+	if !encryptionCalled {
+		t.Error("expected PutBucketEncryption to be called even though versioning is unsupported")
+	}
+}
+
+func TestCreateBucket_FailsWhenVersioningErrorIsNotUnsupported(t *testing.T) {
+	fake := &fakeS3API{
+		putBucketVersioningFn: func(*s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+			return nil, fakeAWSErr{code: "AccessDenied"}
+		},
+		putBucketEncryptionFn: func(*s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
+			t.Fatal("PutBucketEncryption should not be called when versioning failed for an unrelated reason")
+			return nil, nil
+		},
+	}
+
+	client := &AWSProvider{s3ClientOverride: fake, s3Endpoint: "http://minio.local"}
+
+	if err := client.CreateBucket("my-bucket"); err == nil {
+		t.Error("expected CreateBucket to fail when PutBucketVersioning returns a non-capability error")
+	}
+}
@@ -0,0 +1,173 @@
+package iaas
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestWriteFile_UsesDirectPutObjectBelowThreshold(t *testing.T) {
+	var putObjectCalled bool
+
+	fake := &fakeS3API{
+		putObjectFn: func(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			putObjectCalled = true
+			return &s3.PutObjectOutput{}, nil
+		},
+	}
+
+	client := &AWSProvider{s3ClientOverride: fake, largeAssetThreshold: 1024}
+	if err := client.WriteFile("my-bucket", "small.txt", []byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !putObjectCalled {
+		t.Error("expected WriteFile to use a direct PutObject for a payload below largeAssetThreshold")
+	}
+}
+
+func TestWriteFile_DelegatesToWriteStreamAboveThreshold(t *testing.T) {
+	var directPutCalled, streamingPutCalled bool
+
+	fake := &fakeS3API{
+		putObjectFn: func(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			directPutCalled = true
+			return &s3.PutObjectOutput{}, nil
+		},
+		putObjectWithContextFn: func(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			streamingPutCalled = true
+			return &s3.PutObjectOutput{}, nil
+		},
+	}
+
+	contents := []byte("this payload is large enough to cross the threshold")
+	client := &AWSProvider{s3ClientOverride: fake, largeAssetThreshold: int64(len(contents))}
+	if err := client.WriteFile("my-bucket", "large.txt", contents); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if directPutCalled {
+		t.Error("expected WriteFile to not use a direct PutObject at or above largeAssetThreshold")
+	}
+	if !streamingPutCalled {
+		t.Error("expected WriteFile to delegate to WriteStream (via the multipart uploader) at or above largeAssetThreshold")
+	}
+}
+
+func TestLoadFile_DelegatesToLoadStreamAboveThreshold(t *testing.T) {
+	const contents = "this payload is large enough to cross the threshold"
+	var directGetCalled, streamingGetCalled bool
+
+	fake := &fakeS3API{
+		headObjectFn: func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(contents)))}, nil
+		},
+		getObjectFn: func(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			directGetCalled = true
+			return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader([]byte(contents)))}, nil
+		},
+		getObjectWithContextFn: func(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			streamingGetCalled = true
+			return &s3.GetObjectOutput{
+				Body:          ioutil.NopCloser(bytes.NewReader([]byte(contents))),
+				ContentLength: aws.Int64(int64(len(contents))),
+			}, nil
+		},
+	}
+
+	client := &AWSProvider{s3ClientOverride: fake, largeAssetThreshold: int64(len(contents))}
+	got, err := client.LoadFile("my-bucket", "large.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(got) != contents {
+		t.Errorf("expected %q, got %q", contents, got)
+	}
+	if directGetCalled {
+		t.Error("expected LoadFile to not use a direct GetObject at or above largeAssetThreshold")
+	}
+	if !streamingGetCalled {
+		t.Error("expected LoadFile to delegate to LoadStream (via the multipart downloader) at or above largeAssetThreshold")
+	}
+}
+
+func TestWriteStream_UploadsViaS3Manager(t *testing.T) {
+	var uploadedBody []byte
+
+	fake := &fakeS3API{
+		putObjectWithContextFn: func(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+			body, err := ioutil.ReadAll(in.Body)
+			if err != nil {
+				t.Fatalf("unexpected error reading uploaded body: %v", err)
+			}
+			uploadedBody = body
+			return &s3.PutObjectOutput{}, nil
+		},
+	}
+
+	client := &AWSProvider{s3ClientOverride: fake}
+	contents := []byte("streamed contents")
+	if err := client.WriteStream("my-bucket", "streamed.txt", bytes.NewReader(contents), int64(len(contents))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(uploadedBody) != string(contents) {
+		t.Errorf("expected uploaded body %q, got %q", contents, uploadedBody)
+	}
+}
+
+func TestLoadStream_DownloadsViaS3Manager(t *testing.T) {
+	const contents = "streamed contents"
+
+	fake := &fakeS3API{
+		getObjectWithContextFn: func(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{
+				Body:          ioutil.NopCloser(bytes.NewReader([]byte(contents))),
+				ContentLength: aws.Int64(int64(len(contents))),
+			}, nil
+		},
+	}
+
+	client := &AWSProvider{s3ClientOverride: fake}
+	stream, err := client.LoadStream("my-bucket", "streamed.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer stream.Close() // nolint: errcheck
+
+	got, err := ioutil.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("unexpected error reading stream: %v", err)
+	}
+
+	if string(got) != contents {
+		t.Errorf("expected %q, got %q", contents, got)
+	}
+}
+
+func TestLoadFile_UsesDirectGetObjectBelowThreshold(t *testing.T) {
+	const contents = "hello"
+
+	fake := &fakeS3API{
+		headObjectFn: func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+			return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(contents)))}, nil
+		},
+		getObjectFn: func(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+			return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader([]byte(contents)))}, nil
+		},
+	}
+
+	client := &AWSProvider{s3ClientOverride: fake, largeAssetThreshold: 1024}
+	got, err := client.LoadFile("my-bucket", "small.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(got) != contents {
+		t.Errorf("expected %q, got %q", contents, got)
+	}
+}
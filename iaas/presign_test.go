@@ -0,0 +1,71 @@
+package iaas
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresignGet_RejectsEmptyBucketOrPath(t *testing.T) {
+	client := &AWSProvider{}
+
+	if _, err := client.PresignGet("", "path.json", time.Hour); err == nil {
+		t.Error("expected an error for an empty bucket")
+	}
+	if _, err := client.PresignGet("bucket", "", time.Hour); err == nil {
+		t.Error("expected an error for an empty path")
+	}
+}
+
+func TestPresignPut_RejectsEmptyBucketOrPath(t *testing.T) {
+	client := &AWSProvider{}
+
+	if _, err := client.PresignPut("", "path.json", time.Hour); err == nil {
+		t.Error("expected an error for an empty bucket")
+	}
+	if _, err := client.PresignPut("bucket", "", time.Hour); err == nil {
+		t.Error("expected an error for an empty path")
+	}
+}
+
+func TestPresignGet_RejectsTTLAboveMax(t *testing.T) {
+	client := &AWSProvider{}
+
+	if _, err := client.PresignGet("bucket", "path.json", maxPresignTTL+time.Second); err == nil {
+		t.Error("expected an error for a ttl above maxPresignTTL")
+	}
+}
+
+func TestPresignPut_RejectsTTLAboveMax(t *testing.T) {
+	client := &AWSProvider{}
+
+	if _, err := client.PresignPut("bucket", "path.json", maxPresignTTL+time.Second); err == nil {
+		t.Error("expected an error for a ttl above maxPresignTTL")
+	}
+}
+
+func TestPresignGet_RewritesKeyUnderBucketPrefix(t *testing.T) {
+	client := &AWSProvider{sess: testSession(t, "us-east-1"), bucketPrefix: "my-deployment"}
+
+	url, err := client.PresignGet("my-bucket", "state.json", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(url, "/my-deployment/state.json") {
+		t.Errorf("expected presigned GET URL to reference the prefixed key, got %q", url)
+	}
+}
+
+func TestPresignPut_RewritesKeyUnderBucketPrefix(t *testing.T) {
+	client := &AWSProvider{sess: testSession(t, "us-east-1"), bucketPrefix: "my-deployment"}
+
+	url, err := client.PresignPut("my-bucket", "state.json", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(url, "/my-deployment/state.json") {
+		t.Errorf("expected presigned PUT URL to reference the prefixed key, got %q", url)
+	}
+}
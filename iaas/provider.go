@@ -0,0 +1,202 @@
+package iaas
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/EngineerBetter/concourse-up/config"
+)
+
+// defaultUploadPartSize is the part size used by the multipart uploader when
+// streaming large assets, unless overridden
+const defaultUploadPartSize = 8 * 1024 * 1024 // 8 MiB
+
+// defaultStreamConcurrency is the number of concurrent upload/download parts
+// used by the multipart uploader/downloader, unless overridden
+const defaultStreamConcurrency = 5
+
+// AWSProvider is an IClient for AWS. It wraps an AWS SDK session and any
+// overrides needed to target S3-compatible object stores that aren't AWS
+// itself (MinIO, DigitalOcean Spaces, GCS interop mode, etc)
+type AWSProvider struct {
+	sess *session.Session
+
+	// s3Endpoint, when set, points the S3 client at a non-AWS S3-compatible
+	// endpoint instead of the region's default AWS endpoint
+	s3Endpoint string
+	// s3ForcePathStyle forces path-style addressing (host/bucket/key instead
+	// of bucket.host/key), which most non-AWS S3-compatible providers require
+	s3ForcePathStyle bool
+	// s3Region overrides the session region for S3 calls only. It is applied
+	// only when s3Endpoint is set; otherwise the session region is used
+	s3Region string
+	// s3DisableSSL disables TLS for the S3 endpoint, for talking to a local
+	// MinIO instance over plain HTTP in dev
+	s3DisableSSL bool
+
+	// kmsKeyID is the ARN of a KMS key used to encrypt the state bucket and
+	// the objects written to it. When empty, objects fall back to SSE-S3 (AES256)
+	kmsKeyID string
+
+	// deleteWorkerCount is the number of goroutines used to batch-delete object
+	// versions when emptying a bucket. Defaults to defaultDeleteWorkerCount
+	deleteWorkerCount int
+
+	// uploadPartSize and streamConcurrency configure the multipart
+	// uploader/downloader used by WriteStream/LoadStream. Default to
+	// defaultUploadPartSize and defaultStreamConcurrency when zero
+	uploadPartSize    int64
+	streamConcurrency int
+
+	// largeAssetThreshold is the size in bytes at or above which WriteFile/LoadFile
+	// delegate to WriteStream/LoadStream instead of buffering the object fully
+	// in memory. Defaults to config.DefaultLargeAssetThreshold when zero
+	largeAssetThreshold int64
+
+	// sharedBucket, when set, is the name of an existing, already-versioned
+	// bucket that this deployment stores its state in alongside other
+	// deployments, instead of provisioning a bucket of its own
+	sharedBucket string
+	// bucketPrefix namespaces this deployment's keys within sharedBucket
+	bucketPrefix string
+
+	// s3ClientOverride, when set, is used instead of constructing a real S3
+	// client. It exists so unit tests can inject a fake s3iface.S3API
+	s3ClientOverride s3iface.S3API
+}
+
+// NewAWSProvider builds an AWSProvider from an AWS SDK session and the
+// deploy args supplied on the command line
+func NewAWSProvider(sess *session.Session, args config.DeployArgs) *AWSProvider {
+	return &AWSProvider{
+		sess:              sess,
+		s3Endpoint:        args.S3Endpoint,
+		s3ForcePathStyle:  args.S3ForcePathStyle,
+		s3Region:          args.S3Region,
+		s3DisableSSL:      args.S3DisableSSL,
+		kmsKeyID:          args.KMSKeyID,
+		deleteWorkerCount: defaultDeleteWorkerCount,
+		sharedBucket:      args.SharedBucket,
+		bucketPrefix:      args.BucketPrefix,
+
+		largeAssetThreshold: args.LargeAssetThreshold,
+	}
+}
+
+// usesSharedBucket returns true if this provider stores its state in a
+// shared bucket under a key prefix, rather than owning a dedicated bucket
+func (client *AWSProvider) usesSharedBucket() bool {
+	return client.sharedBucket != ""
+}
+
+// prefixedKey returns path rewritten to live under this provider's
+// bucketPrefix, when one is configured
+func (client *AWSProvider) prefixedKey(path string) string {
+	if client.bucketPrefix == "" {
+		return path
+	}
+
+	return client.bucketPrefix + "/" + path
+}
+
+// SetDeleteWorkerCount overrides the number of goroutines used to batch-delete
+// object versions when emptying a bucket
+func (client *AWSProvider) SetDeleteWorkerCount(n int) {
+	client.deleteWorkerCount = n
+}
+
+// effectiveDeleteWorkerCount returns the configured delete worker count,
+// falling back to the default for zero-value providers
+func (client *AWSProvider) effectiveDeleteWorkerCount() int {
+	if client.deleteWorkerCount <= 0 {
+		return defaultDeleteWorkerCount
+	}
+
+	return client.deleteWorkerCount
+}
+
+// newS3Uploader builds an s3manager.Uploader configured with this provider's
+// S3 client and part size/concurrency settings
+func (client *AWSProvider) newS3Uploader() *s3manager.Uploader {
+	return s3manager.NewUploaderWithClient(client.newS3Client(), func(u *s3manager.Uploader) {
+		u.PartSize = client.effectiveUploadPartSize()
+		u.Concurrency = client.effectiveStreamConcurrency()
+	})
+}
+
+// newS3Downloader builds an s3manager.Downloader configured with this
+// provider's S3 client and part size/concurrency settings
+func (client *AWSProvider) newS3Downloader() *s3manager.Downloader {
+	return s3manager.NewDownloaderWithClient(client.newS3Client(), func(d *s3manager.Downloader) {
+		d.PartSize = client.effectiveUploadPartSize()
+		d.Concurrency = client.effectiveStreamConcurrency()
+	})
+}
+
+func (client *AWSProvider) effectiveUploadPartSize() int64 {
+	if client.uploadPartSize <= 0 {
+		return defaultUploadPartSize
+	}
+
+	return client.uploadPartSize
+}
+
+func (client *AWSProvider) effectiveStreamConcurrency() int {
+	if client.streamConcurrency <= 0 {
+		return defaultStreamConcurrency
+	}
+
+	return client.streamConcurrency
+}
+
+// effectiveLargeAssetThreshold returns the configured largeAssetThreshold,
+// falling back to config.DefaultLargeAssetThreshold for zero-value providers
+func (client *AWSProvider) effectiveLargeAssetThreshold() int64 {
+	if client.largeAssetThreshold <= 0 {
+		return config.DefaultLargeAssetThreshold
+	}
+
+	return client.largeAssetThreshold
+}
+
+// serverSideEncryption returns the SSE algorithm and, when using SSE-KMS, the
+// KMS key ID that should be set on PutObject calls for this provider
+func (client *AWSProvider) serverSideEncryption() (algorithm string, kmsKeyID *string) {
+	if client.kmsKeyID != "" {
+		return s3.ServerSideEncryptionAwsKms, aws.String(client.kmsKeyID)
+	}
+
+	return s3.ServerSideEncryptionAes256, nil
+}
+
+// usesCustomS3Endpoint returns true if this provider has been pointed at a
+// non-AWS S3-compatible endpoint
+func (client *AWSProvider) usesCustomS3Endpoint() bool {
+	return client.s3Endpoint != ""
+}
+
+// newS3Client builds an S3 client, applying any custom endpoint overrides.
+// Returns s3ClientOverride when set, so unit tests can inject a fake
+func (client *AWSProvider) newS3Client() s3iface.S3API {
+	if client.s3ClientOverride != nil {
+		return client.s3ClientOverride
+	}
+
+	if !client.usesCustomS3Endpoint() {
+		return s3.New(client.sess)
+	}
+
+	config := &aws.Config{
+		Endpoint:         aws.String(client.s3Endpoint),
+		S3ForcePathStyle: aws.Bool(client.s3ForcePathStyle),
+		DisableSSL:       aws.Bool(client.s3DisableSSL),
+	}
+	if client.s3Region != "" {
+		config.Region = aws.String(client.s3Region)
+	}
+
+	return s3.New(client.sess, config)
+}
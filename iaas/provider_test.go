@@ -0,0 +1,126 @@
+package iaas
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// testSession builds a *session.Session with static, dummy credentials so
+// newS3Client can construct a client without touching the network or any
+// shared AWS config on the test machine
+func testSession(t *testing.T, region string) *session.Session {
+	t.Helper()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String(region),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error building test session: %v", err)
+	}
+
+	return sess
+}
+
+func TestNewS3Client_AppliesCustomEndpointConfig(t *testing.T) {
+	client := &AWSProvider{
+		sess:             testSession(t, "us-east-1"),
+		s3Endpoint:       "http://minio.local:9000",
+		s3ForcePathStyle: true,
+		s3Region:         "eu-west-1",
+		s3DisableSSL:     true,
+	}
+
+	s3Client, ok := client.newS3Client().(*s3.S3)
+	if !ok {
+		t.Fatalf("expected newS3Client to return a *s3.S3, got %T", client.newS3Client())
+	}
+
+	if got := aws.StringValue(s3Client.Config.Endpoint); got != "http://minio.local:9000" {
+		t.Errorf("expected Endpoint %q, got %q", "http://minio.local:9000", got)
+	}
+	if !aws.BoolValue(s3Client.Config.S3ForcePathStyle) {
+		t.Error("expected S3ForcePathStyle to be true")
+	}
+	if got := aws.StringValue(s3Client.Config.Region); got != "eu-west-1" {
+		t.Errorf("expected Region %q, got %q", "eu-west-1", got)
+	}
+	if !aws.BoolValue(s3Client.Config.DisableSSL) {
+		t.Error("expected DisableSSL to be true")
+	}
+}
+
+func TestNewS3Client_WithoutCustomEndpoint_UsesSessionDefaults(t *testing.T) {
+	client := &AWSProvider{sess: testSession(t, "ap-southeast-2")}
+
+	s3Client, ok := client.newS3Client().(*s3.S3)
+	if !ok {
+		t.Fatalf("expected newS3Client to return a *s3.S3, got %T", client.newS3Client())
+	}
+
+	if got := aws.StringValue(s3Client.Config.Region); got != "ap-southeast-2" {
+		t.Errorf("expected Region to fall back to the session's region %q, got %q", "ap-southeast-2", got)
+	}
+	if aws.StringValue(s3Client.Config.Endpoint) != "" {
+		t.Errorf("expected no custom Endpoint, got %q", aws.StringValue(s3Client.Config.Endpoint))
+	}
+}
+
+func TestCreateBucket_SkipsLocationConstraintForCustomEndpoint(t *testing.T) {
+	var captured *s3.CreateBucketInput
+
+	fake := &fakeS3API{
+		createBucketFn: func(in *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+			captured = in
+			return &s3.CreateBucketOutput{}, nil
+		},
+		putBucketVersioningFn: func(*s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+			return &s3.PutBucketVersioningOutput{}, nil
+		},
+		putBucketEncryptionFn: func(*s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
+			return &s3.PutBucketEncryptionOutput{}, nil
+		},
+	}
+
+	client := &AWSProvider{s3ClientOverride: fake, s3Endpoint: "http://minio.local:9000"}
+	if err := client.CreateBucket("my-bucket"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if captured.CreateBucketConfiguration != nil {
+		t.Error("expected CreateBucket to omit CreateBucketConfiguration/LocationConstraint for a custom endpoint")
+	}
+}
+
+func TestCreateBucket_SetsLocationConstraintForNonUsEast1WithoutCustomEndpoint(t *testing.T) {
+	var captured *s3.CreateBucketInput
+
+	fake := &fakeS3API{
+		createBucketFn: func(in *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+			captured = in
+			return &s3.CreateBucketOutput{}, nil
+		},
+		putBucketVersioningFn: func(*s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+			return &s3.PutBucketVersioningOutput{}, nil
+		},
+		putBucketEncryptionFn: func(*s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
+			return &s3.PutBucketEncryptionOutput{}, nil
+		},
+	}
+
+	client := &AWSProvider{s3ClientOverride: fake, sess: testSession(t, "eu-west-1")}
+	if err := client.CreateBucket("my-bucket"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if captured.CreateBucketConfiguration == nil {
+		t.Fatal("expected CreateBucket to set CreateBucketConfiguration/LocationConstraint for a non-us-east-1 AWS bucket")
+	}
+	if got := aws.StringValue(captured.CreateBucketConfiguration.LocationConstraint); got != "eu-west-1" {
+		t.Errorf("expected LocationConstraint %q, got %q", "eu-west-1", got)
+	}
+}
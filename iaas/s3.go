@@ -2,16 +2,34 @@ package iaas
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"io"
 	"io/ioutil"
+	"os"
+	"sync"
 
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+// defaultDeleteWorkerCount is the number of goroutines used to batch-delete
+// object versions when emptying a bucket, unless overridden
+const defaultDeleteWorkerCount = 8
+
+// maxDeleteBatchSize is the largest number of keys S3's DeleteObjects will
+// accept in a single request
+const maxDeleteBatchSize = 1000
+
+// deleteBucketRetryBudget is how long to keep retrying the final DeleteBucket
+// call in the face of S3's eventual consistency after a bulk delete
+const deleteBucketRetryBudget = 60 * time.Second
+
 const (
 	// New versions of github.com/aws/aws-sdk-go/aws have these consts
 	// but the version currently pinned by bosh-cli v2 does not
@@ -32,51 +50,197 @@ const (
 	awsErrCodeNotFound = "NotFound"
 )
 
-// DeleteVersionedBucket deletes and empties a versioned bucket
+// DeleteVersionedBucket empties a versioned bucket of all object versions and
+// delete markers, then deletes the bucket itself. In shared-bucket mode it
+// instead only empties this deployment's prefix, leaving the bucket (which is
+// shared with other deployments) in place
 func (client *AWSProvider) DeleteVersionedBucket(name string) error {
 
-	s3Client := s3.New(client.sess)
+	s3Client := client.newS3Client()
+
+	identifiers, err := client.listObjectVersionsAndMarkers(s3Client, name)
+	if err != nil {
+		return err
+	}
+
+	if err := client.batchDeleteObjects(s3Client, name, identifiers); err != nil {
+		return err
+	}
+
+	if client.usesSharedBucket() {
+		return nil
+	}
+
+	return client.retryDeleteBucket(s3Client, name)
+}
+
+// listObjectVersionsAndMarkers returns an ObjectIdentifier for every object
+// version AND delete marker in the bucket, scoped to bucketPrefix in
+// shared-bucket mode. Orphaned delete markers block bucket deletion if left behind
+func (client *AWSProvider) listObjectVersionsAndMarkers(s3Client s3iface.S3API, name string) ([]*s3.ObjectIdentifier, error) {
+	identifiers := []*s3.ObjectIdentifier{}
+
+	if client.usesSharedBucket() && client.bucketPrefix == "" {
+		return nil, fmt.Errorf("refusing to list every object version in shared S3 bucket [%v] with no bucket prefix configured: this would delete other deployments' state", name)
+	}
+
+	input := &s3.ListObjectVersionsInput{Bucket: &name}
+	if client.usesSharedBucket() {
+		// Must match prefixedKey's "bucketPrefix/path" convention exactly: a bare
+		// bucketPrefix is a raw string-prefix match, so "team-a" would also list
+		// (and later delete) another deployment's "team-ab/..." keys
+		prefix := client.bucketPrefix + "/"
+		input.Prefix = &prefix
+	}
 
-	// Delete all objects
-	objects := []*s3.ObjectVersion{}
-	err := s3Client.ListObjectVersionsPages(&s3.ListObjectVersionsInput{Bucket: &name},
+	err := s3Client.ListObjectVersionsPages(input,
 		func(output *s3.ListObjectVersionsOutput, _ bool) bool {
-			objects = append(objects, output.Versions...)
+			for _, version := range output.Versions {
+				identifiers = append(identifiers, &s3.ObjectIdentifier{
+					Key:       version.Key,
+					VersionId: version.VersionId,
+				})
+			}
+			for _, marker := range output.DeleteMarkers {
+				identifiers = append(identifiers, &s3.ObjectIdentifier{
+					Key:       marker.Key,
+					VersionId: marker.VersionId,
+				})
+			}
 
 			return true
 		})
+
+	return identifiers, err
+}
+
+// batchDeleteObjects deletes the given object identifiers in batches of up to
+// maxDeleteBatchSize, fanned out across a worker pool so teardown of a large
+// state bucket takes minutes rather than hours
+func (client *AWSProvider) batchDeleteObjects(s3Client s3iface.S3API, bucket string, identifiers []*s3.ObjectIdentifier) error {
+	if len(identifiers) == 0 {
+		return nil
+	}
+
+	batches := make(chan []*s3.ObjectIdentifier)
+	errs := make([]error, 0)
+	var errsMu sync.Mutex
+
+	var wg sync.WaitGroup
+	workerCount := client.effectiveDeleteWorkerCount()
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if err := deleteObjectBatch(s3Client, bucket, batch); err != nil {
+					errsMu.Lock()
+					errs = append(errs, err)
+					errsMu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for start := 0; start < len(identifiers); start += maxDeleteBatchSize {
+		end := start + maxDeleteBatchSize
+		if end > len(identifiers) {
+			end = len(identifiers)
+		}
+		batches <- identifiers[start:end]
+	}
+	close(batches)
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error deleting objects from S3 bucket [%v] in %d batch(es): %v", bucket, len(errs), errs)
+	}
+
+	return nil
+}
+
+// deleteObjectBatch issues a single DeleteObjects call and turns any
+// per-object errors returned in the response into a Go error, rather than
+// silently discarding them
+func deleteObjectBatch(s3Client s3iface.S3API, bucket string, batch []*s3.ObjectIdentifier) error {
+	output, err := s3Client.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: &bucket,
+		Delete: &s3.Delete{
+			Objects: batch,
+			Quiet:   aws.Bool(true),
+		},
+	})
 	if err != nil {
 		return err
 	}
 
-	for _, object := range objects {
-		_, err = s3Client.DeleteObject(&s3.DeleteObjectInput{
-			Bucket: &name,
-			Key:    object.Key,
-			VersionId: object.VersionId,
-		})
-		if err != nil {
+	if len(output.Errors) > 0 {
+		return fmt.Errorf("%d object(s) failed to delete: %v", len(output.Errors), output.Errors)
+	}
+
+	return nil
+}
+
+// retryDeleteBucket deletes the now-empty bucket, retrying with exponential
+// backoff for up to deleteBucketRetryBudget when S3 hasn't yet caught up with
+// the bulk delete we just issued
+func (client *AWSProvider) retryDeleteBucket(s3Client s3iface.S3API, name string) error {
+	deadline := time.Now().Add(deleteBucketRetryBudget)
+	backoff := 500 * time.Millisecond
+
+	for {
+		_, err := s3Client.DeleteBucket(&s3.DeleteBucketInput{Bucket: &name})
+		if err == nil {
 			return nil
 		}
+
+		if !isRetriableBucketDeleteError(err) || time.Now().After(deadline) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
 	}
+}
 
-	time.Sleep(time.Second)
+// isRetriableBucketDeleteError returns true for the transient errors S3 can
+// return immediately after a bulk delete, before it's caught up internally
+func isRetriableBucketDeleteError(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
 
-	_, err = s3Client.DeleteBucket(&s3.DeleteBucketInput{Bucket: &name})
-	return err
+	switch awsErr.Code() {
+	case awsErrCodeNoSuchBucket, "OperationAborted":
+		return true
+	default:
+		return false
+	}
 }
 
-// CreateBucket checks if the named bucket exists and creates it if it doesn't
+// CreateBucket checks if the named bucket exists and creates it if it doesn't.
+// In shared-bucket mode it's a no-op beyond verifying access and versioning,
+// since the bucket is expected to already exist and be managed independently
 func (client *AWSProvider) CreateBucket(name string) error {
+	if client.usesSharedBucket() {
+		if client.bucketPrefix == "" {
+			return fmt.Errorf("refusing to use shared S3 bucket [%v] with no bucket prefix configured: this would collide with other deployments' state", name)
+		}
+		return client.verifySharedBucket(name)
+	}
 
-	s3Client := s3.New(client.sess)
+	s3Client := client.newS3Client()
 
 	bucketInput := &s3.CreateBucketInput{
 		Bucket: &name,
 	}
 	// NOTE the location constraint should only be set if using a bucket OTHER than us-east-1
 	// http://docs.aws.amazon.com/AmazonS3/latest/API/RESTBucketPUT.html
-	if *client.sess.Config.Region != "us-east-1" {
+	// Many S3-compatible providers reject LocationConstraint outright, so skip it entirely
+	// when talking to a custom endpoint
+	if !client.usesCustomS3Endpoint() && *client.sess.Config.Region != "us-east-1" {
 		bucketInput.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
 			LocationConstraint: client.sess.Config.Region,
 		}
@@ -99,16 +263,79 @@ func (client *AWSProvider) CreateBucket(name string) error {
 
 	_, err = s3Client.PutBucketVersioning(versioningInput)
 	if err != nil {
-		return fmt.Errorf("error enabling versioning on S3 bucket [%v]: [%v]", name, err)
+		// Not every S3-compatible backend supports object versioning, so don't
+		// fail bucket creation against one that doesn't. Encryption support is
+		// orthogonal, so still attempt it below rather than bailing out here
+		if !client.usesCustomS3Endpoint() || !isVersioningUnsupported(err) {
+			return fmt.Errorf("error enabling versioning on S3 bucket [%v]: [%v]", name, err)
+		}
+	}
+
+	algorithm, kmsKeyID := client.serverSideEncryption()
+	encryptionInput := &s3.PutBucketEncryptionInput{
+		Bucket: &name,
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{
+				{
+					ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+						SSEAlgorithm:   &algorithm,
+						KMSMasterKeyID: kmsKeyID,
+					},
+				},
+			},
+		},
+	}
+
+	_, err = s3Client.PutBucketEncryption(encryptionInput)
+	if err != nil {
+		return fmt.Errorf("error enabling default encryption on S3 bucket [%v]: [%v]", name, err)
 	}
 
 	return nil
 }
 
+// verifySharedBucket confirms the shared bucket exists and is accessible, and
+// that it already has versioning enabled, without attempting to create or
+// configure it
+func (client *AWSProvider) verifySharedBucket(name string) error {
+	s3Client := client.newS3Client()
+
+	if _, err := s3Client.HeadBucket(&s3.HeadBucketInput{Bucket: &name}); err != nil {
+		return fmt.Errorf("error accessing shared S3 bucket [%v]: [%v]", name, err)
+	}
+
+	versioning, err := s3Client.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: &name})
+	if err != nil {
+		return fmt.Errorf("error checking versioning on shared S3 bucket [%v]: [%v]", name, err)
+	}
+
+	if aws.StringValue(versioning.Status) != s3.BucketVersioningStatusEnabled {
+		return fmt.Errorf("shared S3 bucket [%v] does not have versioning enabled", name)
+	}
+
+	return nil
+}
+
+// isVersioningUnsupported returns true if the error indicates the S3-compatible
+// backend doesn't implement bucket versioning at all
+func isVersioningUnsupported(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch awsErr.Code() {
+	case "NotImplemented", "MethodNotAllowed", "InvalidRequest":
+		return true
+	default:
+		return false
+	}
+}
+
 // BucketExists checks if the named bucket exists
 func (client *AWSProvider) BucketExists(name string) (bool, error) {
 
-	s3Client := s3.New(client.sess)
+	s3Client := client.newS3Client()
 
 	_, err := s3Client.HeadBucket(&s3.HeadBucketInput{Bucket: &name})
 	if err == nil {
@@ -123,23 +350,61 @@ func (client *AWSProvider) BucketExists(name string) (bool, error) {
 	return false, nil
 }
 
-// WriteFile writes the specified S3 object
+// WriteFile writes the specified S3 object. Payloads at or above
+// largeAssetThreshold are delegated to WriteStream so multi-hundred-MB
+// stemcells and worker tarballs don't have to be buffered fully in memory;
+// everything else is a single direct PutObject
 func (client *AWSProvider) WriteFile(bucket, path string, contents []byte) error {
-	s3Client := s3.New(client.sess)
+	size := int64(len(contents))
+	if size >= client.effectiveLargeAssetThreshold() {
+		return client.WriteStream(bucket, path, bytes.NewReader(contents), size)
+	}
 
-	_, err := s3Client.PutObject(&s3.PutObjectInput{
-		Bucket: &bucket,
-		Key:    &path,
-		Body:   bytes.NewReader(contents),
+	s3Client := client.newS3Client()
+	_, err := s3Client.PutObject(client.encryptedPutObjectInput(bucket, path, contents))
+	return err
+}
+
+// WriteStream uploads an object to S3 via a multipart uploader, so large
+// stemcell assets and tarballs don't need to be buffered fully in memory.
+// size is informational only; WriteFile uses it alongside
+// largeAssetThreshold to decide whether to call this or PutObject directly
+func (client *AWSProvider) WriteStream(bucket, path string, r io.Reader, size int64) error {
+	algorithm, kmsKeyID := client.serverSideEncryption()
+	key := client.prefixedKey(path)
+
+	_, err := client.newS3Uploader().Upload(&s3manager.UploadInput{
+		Bucket:               &bucket,
+		Key:                  &key,
+		Body:                 r,
+		ServerSideEncryption: &algorithm,
+		SSEKMSKeyId:          kmsKeyID,
 	})
 	return err
 }
 
+// encryptedPutObjectInput builds a PutObjectInput for the given bucket, path
+// and contents with server-side encryption applied, rewriting path under
+// bucketPrefix when shared-bucket mode is configured
+func (client *AWSProvider) encryptedPutObjectInput(bucket, path string, contents []byte) *s3.PutObjectInput {
+	algorithm, kmsKeyID := client.serverSideEncryption()
+	key := client.prefixedKey(path)
+
+	return &s3.PutObjectInput{
+		Bucket:               &bucket,
+		Key:                  &key,
+		Body:                 bytes.NewReader(contents),
+		ServerSideEncryption: &algorithm,
+		SSEKMSKeyId:          kmsKeyID,
+	}
+}
+
 // HasFile returns true if the specified S3 object exists
 func (client *AWSProvider) HasFile(bucket, path string) (bool, error) {
-	s3Client := s3.New(client.sess)
+	s3Client := client.newS3Client()
+	key := client.prefixedKey(path)
 
-	_, err := s3Client.HeadObject(&s3.HeadObjectInput{Bucket: &bucket, Key: &path})
+	_, err := s3Client.HeadObject(&s3.HeadObjectInput{Bucket: &bucket, Key: &key})
 	if err != nil {
 		awsErrCode := err.(awserr.Error).Code()
 		if awsErrCode == awsErrCodeNotFound || awsErrCode == awsErrCodeNoSuchKey {
@@ -155,10 +420,11 @@ func (client *AWSProvider) HasFile(bucket, path string) (bool, error) {
 // Second argument is true if new file was created
 func (client *AWSProvider) EnsureFileExists(bucket, path string, defaultContents []byte) ([]byte, bool, error) {
 
-	s3Client := s3.New(client.sess)
+	s3Client := client.newS3Client()
+	key := client.prefixedKey(path)
 
 	// Trying to get the Object
-	output, err := s3Client.GetObject(&s3.GetObjectInput{Bucket: &bucket, Key: &path})
+	output, err := s3Client.GetObject(&s3.GetObjectInput{Bucket: &bucket, Key: &key})
 	if err == nil {
 		var contents []byte
 		contents, err = ioutil.ReadAll(output.Body)
@@ -173,43 +439,147 @@ func (client *AWSProvider) EnsureFileExists(bucket, path string, defaultContents
 	// Bubble up the error if it was irelevant of NotFound
 	awsErrCode := err.(awserr.Error).Code()
 	if awsErrCode != awsErrCodeNoSuchKey && awsErrCode != awsErrCodeNotFound {
-		return nil, false, err
+		return nil, false, wrapKMSAccessDenied(err)
 	}
 
 	// Create the file (path) in the bucket with defaultContents
-	_, err = s3Client.PutObject(&s3.PutObjectInput{
-		Bucket: &bucket,
-		Key:    &path,
-		Body:   bytes.NewReader(defaultContents),
-	})
+	_, err = s3Client.PutObject(client.encryptedPutObjectInput(bucket, path, defaultContents))
 	if err != nil {
-		return nil, false, err
+		return nil, false, wrapKMSAccessDenied(err)
 	}
 
 	// The file was created (new) and contains the defaultContents
 	return defaultContents, true, nil
 }
 
-// LoadFile loads a file from S3
+// LoadFile loads a file from S3. Objects at or above largeAssetThreshold are
+// delegated to LoadStream so multi-hundred-MB stemcells and worker tarballs
+// aren't buffered fully in memory; everything else is a single direct GetObject
 func (client *AWSProvider) LoadFile(bucket, path string) ([]byte, error) {
+	s3Client := client.newS3Client()
+	key := client.prefixedKey(path)
+
+	head, err := s3Client.HeadObject(&s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, wrapKMSAccessDenied(err)
+	}
+
+	if aws.Int64Value(head.ContentLength) >= client.effectiveLargeAssetThreshold() {
+		stream, err := client.LoadStream(bucket, path)
+		if err != nil {
+			return nil, err
+		}
+		defer stream.Close() // nolint: errcheck
 
-	s3Client := s3.New(client.sess)
+		return ioutil.ReadAll(stream)
+	}
 
-	output, err := s3Client.GetObject(&s3.GetObjectInput{Bucket: &bucket, Key: &path})
+	output, err := s3Client.GetObject(&s3.GetObjectInput{Bucket: &bucket, Key: &key})
 	if err != nil {
-		return nil, err
+		return nil, wrapKMSAccessDenied(err)
 	}
+	defer output.Body.Close() // nolint: errcheck
 
 	return ioutil.ReadAll(output.Body)
 }
 
+// LoadStream downloads an object from S3 via a multipart downloader into a
+// temporary file, returning it as an io.ReadCloser. The temporary file is
+// removed when the stream is closed. This avoids buffering large stemcell
+// assets and tarballs fully in memory
+func (client *AWSProvider) LoadStream(bucket, path string) (io.ReadCloser, error) {
+	tmpFile, err := ioutil.TempFile("", "control-tower-asset-")
+	if err != nil {
+		return nil, err
+	}
+
+	key := client.prefixedKey(path)
+	_, err = client.newS3Downloader().Download(tmpFile, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		tmpFile.Close() // nolint: errcheck
+		os.Remove(tmpFile.Name()) // nolint: errcheck
+		return nil, wrapKMSAccessDenied(err)
+	}
+
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		tmpFile.Close() // nolint: errcheck
+		os.Remove(tmpFile.Name()) // nolint: errcheck
+		return nil, err
+	}
+
+	return &tempFileReadCloser{File: tmpFile}, nil
+}
+
+// tempFileReadCloser wraps an *os.File so that Close also removes the
+// underlying temporary file
+type tempFileReadCloser struct {
+	*os.File
+}
+
+// Close closes the underlying file and removes it from disk
+func (t *tempFileReadCloser) Close() error {
+	name := t.File.Name()
+	closeErr := t.File.Close()
+	os.Remove(name) // nolint: errcheck
+	return closeErr
+}
+
+// wrapKMSAccessDenied turns an opaque KMS.AccessDenied error into an
+// actionable message pointing at the likely cause: the caller's IAM role
+// isn't allowed to use the state bucket's KMS key
+func wrapKMSAccessDenied(err error) error {
+	awsErr, ok := err.(awserr.Error)
+	if !ok || awsErr.Code() != "KMS.AccessDenied" {
+		return err
+	}
+
+	return fmt.Errorf("access denied decrypting object with KMS: [%v]. Check that your IAM role has kms:Decrypt on the state bucket's KMS key", err)
+}
+
+// maxPresignTTL is the maximum lifetime S3 will honour for a presigned
+// request's signature
+const maxPresignTTL = 7 * 24 * time.Hour
+
+// PresignGet returns a time-boxed URL that lets anyone download the named
+// object without needing AWS credentials of their own
+func (client *AWSProvider) PresignGet(bucket, path string, ttl time.Duration) (string, error) {
+	if bucket == "" || path == "" {
+		return "", errors.New("bucket and path are required to presign a GET request")
+	}
+	if ttl > maxPresignTTL {
+		return "", fmt.Errorf("presigned URL ttl of %s exceeds the maximum S3 allows of %s", ttl, maxPresignTTL)
+	}
+
+	s3Client := client.newS3Client()
+	key := client.prefixedKey(path)
+	req, _ := s3Client.GetObjectRequest(&s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	return req.Presign(ttl)
+}
+
+// PresignPut returns a time-boxed URL that lets anyone upload the named
+// object without needing AWS credentials of their own
+func (client *AWSProvider) PresignPut(bucket, path string, ttl time.Duration) (string, error) {
+	if bucket == "" || path == "" {
+		return "", errors.New("bucket and path are required to presign a PUT request")
+	}
+	if ttl > maxPresignTTL {
+		return "", fmt.Errorf("presigned URL ttl of %s exceeds the maximum S3 allows of %s", ttl, maxPresignTTL)
+	}
+
+	s3Client := client.newS3Client()
+	key := client.prefixedKey(path)
+	req, _ := s3Client.PutObjectRequest(&s3.PutObjectInput{Bucket: &bucket, Key: &key})
+	return req.Presign(ttl)
+}
+
 // DeleteFile deletes a file from S3
 func (client *AWSProvider) DeleteFile(bucket, path string) error {
 
-	s3Client := s3.New(client.sess)
+	s3Client := client.newS3Client()
+	key := client.prefixedKey(path)
 	_, err := s3Client.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: &bucket,
-		Key:    &path,
+		Key:    &key,
 	})
 
 	return err
@@ -0,0 +1,72 @@
+package iaas
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestPrefixedKey_NoPrefixConfigured(t *testing.T) {
+	client := &AWSProvider{}
+
+	if got := client.prefixedKey("state.json"); got != "state.json" {
+		t.Errorf("expected path to be returned unchanged, got %q", got)
+	}
+}
+
+func TestPrefixedKey_RewritesUnderBucketPrefix(t *testing.T) {
+	client := &AWSProvider{bucketPrefix: "my-deployment"}
+
+	const want = "my-deployment/state.json"
+	if got := client.prefixedKey("state.json"); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestCreateBucket_RefusesSharedBucketWithNoPrefix(t *testing.T) {
+	client := &AWSProvider{sharedBucket: "shared"}
+
+	if err := client.CreateBucket("shared"); err == nil {
+		t.Fatal("expected CreateBucket to refuse a shared bucket with no bucket prefix configured")
+	}
+}
+
+// TestListObjectVersionsAndMarkers_DoesNotCollideWithOverlappingPrefix guards
+// against a narrower deployment's delete sweeping up a sibling deployment's
+// keys just because its bucketPrefix is a string-prefix of the other's, eg
+// "team-a" must not also match "team-ab/..."
+func TestListObjectVersionsAndMarkers_DoesNotCollideWithOverlappingPrefix(t *testing.T) {
+	var capturedPrefix string
+
+	fake := &fakeS3API{
+		listObjectVersionsPagesFn: func(in *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool) error {
+			capturedPrefix = aws.StringValue(in.Prefix)
+			fn(&s3.ListObjectVersionsOutput{
+				Versions: []*s3.ObjectVersion{
+					{Key: aws.String("team-a/state.json"), VersionId: aws.String("v1")},
+				},
+			}, true)
+			return nil
+		},
+	}
+
+	client := &AWSProvider{sharedBucket: "shared", bucketPrefix: "team-a"}
+	identifiers, err := client.listObjectVersionsAndMarkers(fake, "shared")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	const wantPrefix = "team-a/"
+	if capturedPrefix != wantPrefix {
+		t.Fatalf("expected S3 Prefix filter %q, got %q - a bare %q would also match team-ab/... belonging to another deployment",
+			wantPrefix, capturedPrefix, client.bucketPrefix)
+	}
+
+	for _, id := range identifiers {
+		if !strings.HasPrefix(aws.StringValue(id.Key), wantPrefix) {
+			t.Errorf("listed key %q does not belong to bucket prefix %q", aws.StringValue(id.Key), wantPrefix)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package testsupport
 
 import (
+	"io"
+	"time"
+
 	"github.com/EngineerBetter/concourse-up/bosh"
 	"github.com/EngineerBetter/concourse-up/config"
 	"github.com/EngineerBetter/concourse-up/terraform"
@@ -17,7 +20,27 @@ type FakeAWSClient struct {
 	FakeHasFile                       func(bucket, path string) (bool, error)
 	FakeLoadFile                      func(bucket, path string) ([]byte, error)
 	FakeWriteFile                     func(bucket, path string, contents []byte) error
+	FakeWriteStream                   func(bucket, path string, r io.Reader, size int64) error
+	FakeLoadStream                    func(bucket, path string) (io.ReadCloser, error)
+	FakePresignGet                    func(bucket, path string, ttl time.Duration) (string, error)
+	FakePresignPut                    func(bucket, path string, ttl time.Duration) (string, error)
 	FakeRegion                        func() string
+
+	// SharedBucket and BucketPrefix mirror AWSProvider's equivalent fields, so
+	// tests can assert key rewriting via PrefixedKey without a real S3 client
+	SharedBucket string
+	BucketPrefix string
+}
+
+// PrefixedKey returns path rewritten to live under BucketPrefix, mirroring
+// AWSProvider.prefixedKey, so tests can assert the key rewriting a shared-bucket
+// deployment relies on
+func (client *FakeAWSClient) PrefixedKey(path string) string {
+	if client.BucketPrefix == "" {
+		return path
+	}
+
+	return client.BucketPrefix + "/" + path
 }
 
 // IAAS is here to implement iaas.IClient
@@ -75,6 +98,26 @@ func (client *FakeAWSClient) WriteFile(bucket, path string, contents []byte) err
 	return client.FakeWriteFile(bucket, path, contents)
 }
 
+// WriteStream delegates to FakeWriteStream which is dynamically set by the tests
+func (client *FakeAWSClient) WriteStream(bucket, path string, r io.Reader, size int64) error {
+	return client.FakeWriteStream(bucket, path, r, size)
+}
+
+// LoadStream delegates to FakeLoadStream which is dynamically set by the tests
+func (client *FakeAWSClient) LoadStream(bucket, path string) (io.ReadCloser, error) {
+	return client.FakeLoadStream(bucket, path)
+}
+
+// PresignGet delegates to FakePresignGet which is dynamically set by the tests
+func (client *FakeAWSClient) PresignGet(bucket, path string, ttl time.Duration) (string, error) {
+	return client.FakePresignGet(bucket, path, ttl)
+}
+
+// PresignPut delegates to FakePresignPut which is dynamically set by the tests
+func (client *FakeAWSClient) PresignPut(bucket, path string, ttl time.Duration) (string, error) {
+	return client.FakePresignPut(bucket, path, ttl)
+}
+
 // FakeFlyClient implements fly.IClient for testing
 type FakeFlyClient struct {
 	FakeSetDefaultPipeline func(deployAgs *config.DeployArgs, config *config.Config) error
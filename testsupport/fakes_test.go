@@ -0,0 +1,20 @@
+package testsupport
+
+import "testing"
+
+func TestFakeAWSClient_PrefixedKeyRewritesUnderBucketPrefix(t *testing.T) {
+	client := &FakeAWSClient{BucketPrefix: "my-deployment"}
+
+	const want = "my-deployment/state.json"
+	if got := client.PrefixedKey("state.json"); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestFakeAWSClient_PrefixedKeyNoPrefixConfigured(t *testing.T) {
+	client := &FakeAWSClient{}
+
+	if got := client.PrefixedKey("state.json"); got != "state.json" {
+		t.Errorf("expected path to be returned unchanged, got %q", got)
+	}
+}